@@ -0,0 +1,231 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net"
+
+	"vitess.io/vitess/go/mysql"
+)
+
+// AuthProvider authenticates a MySQL client during the handshake and maps
+// the authenticated principal to a Dolt user name used for the rest of the
+// session.
+type AuthProvider interface {
+	// Name identifies this provider in logs and error messages, e.g.
+	// "static", "htpasswd", "ldap", "jwt".
+	Name() string
+	// Authenticate is called with the user name and password (or bearer
+	// token, for the jwt provider) presented by the client. It returns the
+	// Dolt user name the session should run as.
+	Authenticate(ctx context.Context, user, credential string) (string, error)
+}
+
+// staticAuthProvider reproduces the original single user/password behavior.
+type staticAuthProvider struct {
+	user     string
+	password string
+}
+
+var _ AuthProvider = (*staticAuthProvider)(nil)
+
+func NewStaticAuthProvider(user, password string) AuthProvider {
+	return &staticAuthProvider{user: user, password: password}
+}
+
+func (p *staticAuthProvider) Name() string { return "static" }
+
+func (p *staticAuthProvider) Authenticate(ctx context.Context, user, credential string) (string, error) {
+	if user != p.user || credential != p.password {
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+	return user, nil
+}
+
+// AuthConfig describes the `auth:` YAML block selecting and configuring one
+// of the built-in AuthProvider implementations.
+type AuthConfig struct {
+	Provider string           `yaml:"provider,omitempty"`
+	Htpasswd *HtpasswdConfig  `yaml:"htpasswd,omitempty"`
+	LDAP     *LDAPConfig      `yaml:"ldap,omitempty"`
+	JWT      *JWTConfig       `yaml:"jwt,omitempty"`
+}
+
+type HtpasswdConfig struct {
+	// Path is the htpasswd file on disk. It is re-read whenever its mtime
+	// changes, so operators can rotate credentials without restarting the
+	// server.
+	Path string `yaml:"path"`
+}
+
+type LDAPConfig struct {
+	URL          string `yaml:"url"`
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+	UserBaseDN   string `yaml:"user_base_dn"`
+	UserFilter   string `yaml:"user_filter"`
+}
+
+type JWTConfig struct {
+	JWKSURL      string            `yaml:"jwks_url"`
+	ClaimToRole  map[string]string `yaml:"claim_to_role"`
+}
+
+// authServerAdapter bridges an AuthProvider to the vitess mysql.AuthServer
+// interface expected by go-mysql-server's listener. When the connection
+// presents a verified client certificate, certUsers (populated by
+// secureTransportListener at accept time) is consulted first, and the
+// certificate's mapped user is trusted in place of running Authenticate.
+type authServerAdapter struct {
+	provider  AuthProvider
+	certUsers *certUserRegistry
+	gov       *Governor
+	metrics   *Metrics
+}
+
+var _ mysql.AuthServer = (*authServerAdapter)(nil)
+
+func newAuthServer(provider AuthProvider, certUsers *certUserRegistry, gov *Governor, metrics *Metrics) *authServerAdapter {
+	return &authServerAdapter{provider: provider, certUsers: certUsers, gov: gov, metrics: metrics}
+}
+
+// AuthMethod picks the MySQL auth plugin the client is told to negotiate.
+// The static provider knows the plaintext password up front, so it can be
+// verified the standard way, against the mysql_native_password scramble,
+// without the password ever crossing the wire. The other providers
+// (htpasswd, ldap, jwt) have nothing to scramble-compare against: htpasswd
+// only holds a bcrypt hash, and ldap/jwt delegate to an external system that
+// itself needs the literal credential. Those require the client to send the
+// credential in the clear, so it is protected only when the listener is
+// configured with TLS (listener.tls:); nothing here requires TLS for these
+// providers.
+func (a *authServerAdapter) AuthMethod(user string) (string, error) {
+	if _, ok := a.provider.(*staticAuthProvider); ok {
+		return mysql.MysqlNativePassword, nil
+	}
+	return mysql.MysqlClearPassword, nil
+}
+
+func (a *authServerAdapter) Salt() ([]byte, error) {
+	return mysql.NewSalt()
+}
+
+// nativePasswordScramble computes the mysql_native_password response a
+// client would send for password given salt: SHA1(password) XOR
+// SHA1(salt + SHA1(SHA1(password))), matching vitess's own
+// AuthServerStatic.ValidateHash.
+func nativePasswordScramble(salt []byte, password string) []byte {
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(stage2[:])
+	mask := h.Sum(nil)
+
+	scramble := make([]byte, len(stage1))
+	for i := range stage1 {
+		scramble[i] = stage1[i] ^ mask[i]
+	}
+	return scramble
+}
+
+// validateNativePasswordScramble reports whether authResponse is the
+// mysql_native_password scramble of password for salt. A client with no
+// password sends an empty authResponse regardless of salt.
+func validateNativePasswordScramble(salt []byte, password string, authResponse []byte) bool {
+	if password == "" {
+		return len(authResponse) == 0
+	}
+	expected := nativePasswordScramble(salt, password)
+	if len(authResponse) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		if authResponse[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *authServerAdapter) ValidateHash(salt []byte, user string, authResponse []byte, remoteAddr net.Addr) (mysql.Getter, error) {
+	role := ""
+	if a.certUsers != nil {
+		role = a.certUsers.get(remoteAddr.String())
+	}
+
+	if role == "" {
+		if sp, ok := a.provider.(*staticAuthProvider); ok {
+			// AuthMethod advertised mysql_native_password for the static
+			// provider, so authResponse is a scramble, not the password
+			// itself: verify it against the known plaintext instead of
+			// handing the scrambled bytes to Authenticate.
+			if user != sp.user || !validateNativePasswordScramble(salt, sp.password, authResponse) {
+				return nil, fmt.Errorf("authentication failed for user '%s'", user)
+			}
+			role = sp.user
+		} else {
+			authenticated, err := a.provider.Authenticate(context.Background(), user, string(authResponse))
+			if err != nil {
+				return nil, err
+			}
+			role = authenticated
+		}
+	}
+
+	if a.gov != nil {
+		if err := a.gov.AcquireConnection(role); err != nil {
+			return nil, err
+		}
+	}
+	a.metrics.ConnectionOpened()
+	return &mysql.StaticUserData{Username: role}, nil
+}
+
+func (a *authServerAdapter) Negotiate(c *mysql.Conn, user string, remoteAddr net.Addr) (mysql.Getter, error) {
+	return nil, fmt.Errorf("negotiate-based auth methods are not supported by provider '%s'", a.provider.Name())
+}
+
+// NewAuthProvider builds the AuthProvider selected by cfg, falling back to
+// the static provider configured from user/password when cfg is nil.
+func NewAuthProvider(cfg *AuthConfig, staticUser, staticPassword string) (AuthProvider, error) {
+	if cfg == nil || cfg.Provider == "" || cfg.Provider == "static" {
+		return NewStaticAuthProvider(staticUser, staticPassword), nil
+	}
+
+	switch cfg.Provider {
+	case "htpasswd":
+		if cfg.Htpasswd == nil {
+			return nil, fmt.Errorf("auth provider 'htpasswd' requires an htpasswd: block")
+		}
+		return newHtpasswdAuthProvider(cfg.Htpasswd.Path)
+	case "ldap":
+		if cfg.LDAP == nil {
+			return nil, fmt.Errorf("auth provider 'ldap' requires an ldap: block")
+		}
+		return newLDAPAuthProvider(*cfg.LDAP), nil
+	case "jwt":
+		if cfg.JWT == nil {
+			return nil, fmt.Errorf("auth provider 'jwt' requires a jwt: block")
+		}
+		return newJWTAuthProvider(*cfg.JWT)
+	default:
+		return nil, fmt.Errorf("unknown auth provider: %s", cfg.Provider)
+	}
+}
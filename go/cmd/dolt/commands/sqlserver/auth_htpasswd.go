@@ -0,0 +1,122 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdAuthProvider authenticates against an htpasswd-style file
+// (`user:bcryptHash` per line), reloading it whenever its contents change on
+// disk.
+type htpasswdAuthProvider struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	users   map[string]string
+}
+
+var _ AuthProvider = (*htpasswdAuthProvider)(nil)
+
+func newHtpasswdAuthProvider(path string) (*htpasswdAuthProvider, error) {
+	p := &htpasswdAuthProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *htpasswdAuthProvider) Name() string { return "htpasswd" }
+
+func (p *htpasswdAuthProvider) Authenticate(ctx context.Context, user, credential string) (string, error) {
+	if err := p.reloadIfChanged(); err != nil {
+		return "", err
+	}
+
+	p.mu.RLock()
+	hash, ok := p.users[user]
+	p.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(credential)); err != nil {
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+
+	return user, nil
+}
+
+func (p *htpasswdAuthProvider) reloadIfChanged() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat htpasswd file '%s': %w", p.path, err)
+	}
+
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	return p.reload()
+}
+
+func (p *htpasswdAuthProvider) reload() error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file '%s': %w", p.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx < 0 {
+			return fmt.Errorf("malformed htpasswd line: %q", line)
+		}
+		users[line[:idx]] = line[idx+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.users = users
+	p.modTime = info.ModTime()
+	p.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksValidSigningMethods are the signing algorithms accepted for a JWKS-
+// verified token. Restricting this list (rather than accepting whatever alg
+// the token itself claims) prevents algorithm-confusion attacks, e.g. a
+// token forged with alg "none" or an HMAC alg keyed with a public RSA/EC
+// value published in the JWKS.
+var jwksValidSigningMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// jwtAuthProvider validates a bearer token presented as the client's
+// password against a JWKS endpoint and maps token claims to a Dolt role via
+// cfg.ClaimToRole.
+type jwtAuthProvider struct {
+	cfg    JWTConfig
+	keyfun jwt.Keyfunc
+}
+
+var _ AuthProvider = (*jwtAuthProvider)(nil)
+
+func newJWTAuthProvider(cfg JWTConfig) (*jwtAuthProvider, error) {
+	jwks, err := keyfunc.Get(cfg.JWKSURL, keyfunc.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks from '%s': %w", cfg.JWKSURL, err)
+	}
+
+	return &jwtAuthProvider{cfg: cfg, keyfun: jwks.Keyfunc}, nil
+}
+
+func (p *jwtAuthProvider) Name() string { return "jwt" }
+
+// Authenticate treats credential as a signed JWT bearer token and ignores
+// user, since the token's claims determine the effective Dolt user/role.
+func (p *jwtAuthProvider) Authenticate(ctx context.Context, user, credential string) (string, error) {
+	token, err := jwt.Parse(credential, p.keyfun, jwt.WithValidMethods(jwksValidSigningMethods))
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("authentication failed: invalid bearer token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", fmt.Errorf("authentication failed: unrecognized token claims")
+	}
+
+	for claim, role := range p.cfg.ClaimToRole {
+		if v, ok := claims[claim]; ok {
+			if s, ok := v.(string); ok && s != "" {
+				return role, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("authentication failed: no claim in token mapped to a role")
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapAuthProvider authenticates by binding to an LDAP/AD directory as the
+// user attempting to connect.
+type ldapAuthProvider struct {
+	cfg LDAPConfig
+}
+
+var _ AuthProvider = (*ldapAuthProvider)(nil)
+
+func newLDAPAuthProvider(cfg LDAPConfig) *ldapAuthProvider {
+	return &ldapAuthProvider{cfg: cfg}
+}
+
+func (p *ldapAuthProvider) Name() string { return "ldap" }
+
+func (p *ldapAuthProvider) Authenticate(ctx context.Context, user, credential string) (string, error) {
+	if credential == "" {
+		// Many LDAP servers treat a bind with a valid DN and an empty
+		// password as an "unauthenticated bind" (RFC 4513 5.1.2) that
+		// succeeds without checking anything, which would let a client
+		// authenticate as any discoverable user by sending no password.
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+
+	conn, err := ldap.DialURL(p.cfg.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to ldap server: %w", err)
+	}
+	defer conn.Close()
+
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return "", fmt.Errorf("ldap service bind failed: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(p.cfg.UserFilter, "%s", ldap.EscapeFilter(user))
+	req := ldap.NewSearchRequest(
+		p.cfg.UserBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+		filter, []string{"dn"}, nil,
+	)
+
+	res, err := conn.Search(req)
+	if err != nil {
+		return "", fmt.Errorf("ldap search failed: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+
+	if err := conn.Bind(res.Entries[0].DN, credential); err != nil {
+		return "", fmt.Errorf("authentication failed for user '%s'", user)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateNativePasswordScramble(t *testing.T) {
+	salt := []byte("0123456789abcdefghij")
+	// Computed independently (Python hashlib) for password "hunter2" and the
+	// salt above, per the mysql_native_password algorithm: SHA1(password)
+	// XOR SHA1(salt + SHA1(SHA1(password))).
+	scramble, err := hex.DecodeString("91dbd90edc75e47da9a48a9defc85dcf99fa4fc4")
+	require.NoError(t, err)
+
+	assert.True(t, validateNativePasswordScramble(salt, "hunter2", scramble))
+	assert.False(t, validateNativePasswordScramble(salt, "wrong", scramble))
+	assert.False(t, validateNativePasswordScramble(salt, "hunter2", scramble[:len(scramble)-1]))
+	assert.True(t, validateNativePasswordScramble(salt, "", nil))
+	assert.False(t, validateNativePasswordScramble(salt, "hunter2", nil))
+}
+
+func TestStaticAuthProvider(t *testing.T) {
+	p := NewStaticAuthProvider("alice", "hunter2")
+
+	user, err := p.Authenticate(context.Background(), "alice", "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user)
+
+	_, err = p.Authenticate(context.Background(), "alice", "wrong")
+	assert.Error(t, err)
+}
+
+func TestHtpasswdAuthProvider(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("alice:"+string(hash)+"\n"), 0644))
+
+	p, err := newHtpasswdAuthProvider(path)
+	require.NoError(t, err)
+
+	user, err := p.Authenticate(context.Background(), "alice", "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user)
+
+	_, err = p.Authenticate(context.Background(), "alice", "wrong")
+	assert.Error(t, err)
+
+	_, err = p.Authenticate(context.Background(), "bob", "hunter2")
+	assert.Error(t, err)
+}
+
+func TestLDAPAuthProviderRejectsEmptyPassword(t *testing.T) {
+	// The unreachable URL never gets dialed: the empty-password check must
+	// short-circuit before any network call, so an "unauthenticated bind"
+	// can never succeed against a permissive LDAP server.
+	p := newLDAPAuthProvider(LDAPConfig{URL: "ldap://127.0.0.1:1"})
+
+	_, err := p.Authenticate(context.Background(), "alice", "")
+	assert.Error(t, err)
+}
+
+// jwksServerForTest serves a JWKS containing the public half of key under
+// kid, for use by newJWTAuthProvider.
+func jwksServerForTest(t *testing.T, kid string, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]interface{}{
+			{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			},
+		},
+	}
+	body, err := json.Marshal(jwks)
+	require.NoError(t, err)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+}
+
+func TestJWTAuthProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	srv := jwksServerForTest(t, "test-key", key)
+	defer srv.Close()
+
+	p, err := newJWTAuthProvider(JWTConfig{
+		JWKSURL:     srv.URL,
+		ClaimToRole: map[string]string{"sub": "alice"},
+	})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user-123"})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	role, err := p.Authenticate(context.Background(), "", signed)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", role)
+}
+
+func TestJWTAuthProviderRejectsAlgNone(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	srv := jwksServerForTest(t, "test-key", key)
+	defer srv.Close()
+
+	p, err := newJWTAuthProvider(JWTConfig{
+		JWKSURL:     srv.URL,
+		ClaimToRole: map[string]string{"sub": "alice"},
+	})
+	require.NoError(t, err)
+
+	// A token claiming alg "none" must never be accepted, even though its
+	// claims would otherwise satisfy ClaimToRole: jwt.WithValidMethods is
+	// what's supposed to reject it before the (nonexistent) signature is
+	// ever checked.
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "user-123"})
+	none.Header["kid"] = "test-key"
+	unsigned, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	_, err = p.Authenticate(context.Background(), "", unsigned)
+	assert.Error(t, err)
+}
@@ -0,0 +1,282 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	gmssql "github.com/dolthub/go-mysql-server/sql"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/sqle"
+)
+
+const (
+	DefaultHeartbeatTimeout = 1 * time.Second
+	DefaultElectionTimeout  = 1 * time.Second
+)
+
+// ClusterConfig describes the `cluster:` YAML block that turns a single
+// sql-server process into one member of a Raft-backed group of peers that
+// agree on which node owns writes for each branch.
+type ClusterConfig struct {
+	NodeID            string   `yaml:"node_id"`
+	Peers             []string `yaml:"peers"`
+	DataDir           string   `yaml:"data_dir"`
+	HeartbeatTimeout  *string  `yaml:"heartbeat_timeout,omitempty"`
+	ElectionTimeout   *string  `yaml:"election_timeout,omitempty"`
+}
+
+// NotLeaderError is returned for a write attempted against a follower,
+// naming the address of the node that should be retried instead.
+type NotLeaderError struct {
+	LeaderAddr string
+}
+
+func (e *NotLeaderError) Error() string {
+	return fmt.Sprintf("not the leader for this branch, redirect to: %s", e.LeaderAddr)
+}
+
+// Cluster wraps the raft.Raft instance that replicates branch-head
+// advancement across a group of dolt sql-server peers.
+type Cluster struct {
+	nodeID string
+	raft   *raft.Raft
+	fsm    *branchHeadFSM
+}
+
+// branchHeadFSM is the raft.FSM whose log is a sequence of branch-head
+// advancements; applying an entry updates the in-memory map of branch name
+// to commit hash that followers serve consistent reads from. Apply runs on
+// raft's own goroutine while CommitHash is read concurrently from query
+// threads, so heads is guarded by mu.
+type branchHeadFSM struct {
+	mu    sync.RWMutex
+	heads map[string]string
+}
+
+var _ raft.FSM = (*branchHeadFSM)(nil)
+
+func newBranchHeadFSM() *branchHeadFSM {
+	return &branchHeadFSM{heads: make(map[string]string)}
+}
+
+// branchAdvance is the payload of a single raft log entry: branch has been
+// advanced to commitHash.
+type branchAdvance struct {
+	Branch     string
+	CommitHash string
+}
+
+func (f *branchHeadFSM) Apply(log *raft.Log) interface{} {
+	var adv branchAdvance
+	if err := decodeBranchAdvance(log.Data, &adv); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heads[adv.Branch] = adv.CommitHash
+	return nil
+}
+
+func (f *branchHeadFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	heads := make(map[string]string, len(f.heads))
+	for k, v := range f.heads {
+		heads[k] = v
+	}
+	return &branchHeadSnapshot{heads: heads}, nil
+}
+
+func (f *branchHeadFSM) Restore(rc ioReadCloser) error {
+	heads, err := decodeBranchHeadSnapshot(rc)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heads = heads
+	return rc.Close()
+}
+
+// CommitHash returns the commit hash the cluster has agreed is the head of
+// branch, and whether any node has ever advanced it.
+func (c *Cluster) CommitHash(branch string) (string, bool) {
+	c.fsm.mu.RLock()
+	defer c.fsm.mu.RUnlock()
+	hash, ok := c.fsm.heads[branch]
+	return hash, ok
+}
+
+// IsLeader reports whether this node is currently the Raft leader, and is
+// therefore allowed to accept writes.
+func (c *Cluster) IsLeader() bool {
+	return c.raft.State() == raft.Leader
+}
+
+// LeaderAddr returns the address of the current Raft leader, or "" if there
+// is none.
+func (c *Cluster) LeaderAddr() string {
+	addr, _ := c.raft.LeaderWithID()
+	return string(addr)
+}
+
+// AdvanceBranch proposes that branch be advanced to commitHash, replicating
+// the change to every peer. Returns a *NotLeaderError if this node is not
+// the leader.
+func (c *Cluster) AdvanceBranch(branch, commitHash string) error {
+	if !c.IsLeader() {
+		return &NotLeaderError{LeaderAddr: c.LeaderAddr()}
+	}
+
+	data, err := encodeBranchAdvance(branchAdvance{Branch: branch, CommitHash: commitHash})
+	if err != nil {
+		return err
+	}
+
+	future := c.raft.Apply(data, 10*time.Second)
+	return future.Error()
+}
+
+// Shutdown stops this node's Raft instance. The node stays in the group's
+// configuration; the remaining peers elect a new leader without it.
+func (c *Cluster) Shutdown() error {
+	return c.raft.Shutdown().Error()
+}
+
+// NewCluster starts a Raft node for cfg, bootstrapping the cluster with every
+// peer in cfg.Peers as a member if this node has no existing Raft state.
+func NewCluster(cfg ClusterConfig) (*Cluster, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cluster data dir '%s': %w", cfg.DataDir, err)
+	}
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(cfg.NodeID)
+	raftCfg.HeartbeatTimeout = durationOrDefault(cfg.HeartbeatTimeout, DefaultHeartbeatTimeout)
+	raftCfg.ElectionTimeout = durationOrDefault(cfg.ElectionTimeout, DefaultElectionTimeout)
+
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft-log.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft log store: %w", err)
+	}
+
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft-stable.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft stable store: %w", err)
+	}
+
+	snapshotStore, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft snapshot store: %w", err)
+	}
+
+	localAddr := peerAddrForNode(cfg.Peers, cfg.NodeID)
+	transport, err := raft.NewTCPTransport(localAddr, nil, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raft transport: %w", err)
+	}
+
+	fsm := newBranchHeadFSM()
+
+	r, err := raft.NewRaft(raftCfg, fsm, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start raft: %w", err)
+	}
+
+	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
+	if err != nil {
+		return nil, err
+	}
+	if !hasState {
+		if err := bootstrapCluster(r, raftCfg, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Cluster{nodeID: cfg.NodeID, raft: r, fsm: fsm}, nil
+}
+
+// enforceClusterLeadership wraps engine so that write statements on a
+// non-leader node are rejected with a *NotLeaderError naming the current
+// leader, instead of being applied locally. A write that runs on the leader
+// proposes the branch's new head through Raft via AdvanceBranch, so
+// followers' FSMs converge on the same commit graph; a follower servicing a
+// read checks its own branch head against that agreed CommitHash and errors
+// out rather than serve a read it hasn't caught up to yet. A nil cluster
+// (standalone mode) is a no-op.
+func enforceClusterLeadership(engine *sqle.Engine, cluster *Cluster) *sqle.Engine {
+	if cluster == nil {
+		return engine
+	}
+	engine.QueryHook = chainQueryHooks(engine.QueryHook, clusterLeadershipHook(cluster))
+	return engine
+}
+
+func clusterLeadershipHook(cluster *Cluster) QueryHook {
+	return func(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error) {
+		branch := currentBranch(ctx)
+
+		if requiredPrivilege(query) == Privilege_Write {
+			if !cluster.IsLeader() {
+				return nil, nil, &NotLeaderError{LeaderAddr: cluster.LeaderAddr()}
+			}
+
+			schema, iter, err := next()
+			if err != nil {
+				return schema, iter, err
+			}
+
+			if branch != "" {
+				if hash := currentCommitHash(ctx); hash != "" {
+					if advErr := cluster.AdvanceBranch(branch, hash); advErr != nil {
+						return nil, nil, fmt.Errorf("failed to replicate branch '%s' to peers: %w", branch, advErr)
+					}
+				}
+			}
+
+			return schema, iter, nil
+		}
+
+		if !cluster.IsLeader() && branch != "" {
+			if agreedHash, known := cluster.CommitHash(branch); known {
+				if localHash := currentCommitHash(ctx); localHash != "" && localHash != agreedHash {
+					return nil, nil, fmt.Errorf("this node has not yet replicated branch '%s' to commit %s, retry against the leader", branch, agreedHash)
+				}
+			}
+		}
+
+		return next()
+	}
+}
+
+func bootstrapCluster(r *raft.Raft, raftCfg *raft.Config, cfg ClusterConfig) error {
+	servers := make([]raft.Server, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(nodeIDFromPeer(peer)),
+			Address: raft.ServerAddress(addrFromPeer(peer)),
+		})
+	}
+	future := r.BootstrapCluster(raft.Configuration{Servers: servers})
+	return future.Error()
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchHeadFSMApply(t *testing.T) {
+	fsm := newBranchHeadFSM()
+
+	data, err := encodeBranchAdvance(branchAdvance{Branch: "main", CommitHash: "abc123"})
+	require.NoError(t, err)
+
+	result := fsm.Apply(&raft.Log{Data: data})
+	assert.Nil(t, result)
+	assert.Equal(t, "abc123", fsm.heads["main"])
+}
+
+func TestPeerAddressing(t *testing.T) {
+	peers := []string{"node1@127.0.0.1:7001", "node2@127.0.0.1:7002"}
+
+	assert.Equal(t, "127.0.0.1:7001", peerAddrForNode(peers, "node1"))
+	assert.Equal(t, "127.0.0.1:7002", peerAddrForNode(peers, "node2"))
+	assert.Equal(t, "node1", nodeIDFromPeer(peers[0]))
+	assert.Equal(t, "127.0.0.1:7001", addrFromPeer(peers[0]))
+}
+
+func TestNotLeaderError(t *testing.T) {
+	err := &NotLeaderError{LeaderAddr: "127.0.0.1:7001"}
+	assert.Contains(t, err.Error(), "127.0.0.1:7001")
+}
+
+// freePort asks the OS for an ephemeral port and immediately releases it, for
+// use as a throwaway raft transport address.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startClusterNode starts a single Raft node among peers and tears it down
+// at test cleanup (if it hasn't already been explicitly shut down).
+func startClusterNode(t *testing.T, peers []string, nodeID string) *Cluster {
+	t.Helper()
+	cfg := ClusterConfig{
+		NodeID:           nodeID,
+		Peers:            peers,
+		DataDir:          t.TempDir(),
+		HeartbeatTimeout: strPtr("100ms"),
+		ElectionTimeout:  strPtr("100ms"),
+	}
+	c, err := NewCluster(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Shutdown() })
+	return c
+}
+
+func strPtr(s string) *string { return &s }
+
+// waitForLeader polls nodes until one reports itself as leader (or the
+// timeout elapses), and returns the first such node.
+func waitForLeader(t *testing.T, nodes []*Cluster) *Cluster {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, n := range nodes {
+			if n != nil && n.IsLeader() {
+				return n
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("no leader elected before deadline")
+	return nil
+}
+
+// TestClusterThreeNodeConvergence starts a 3-node Raft cluster, has the
+// leader advance a branch, kills the leader, and checks that the two
+// surviving nodes elect a new leader and agree on the branch head the old
+// leader had proposed before it died.
+func TestClusterThreeNodeConvergence(t *testing.T) {
+	peers := make([]string, 3)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("node%d@127.0.0.1:%d", i, freePort(t))
+	}
+
+	nodes := make([]*Cluster, 3)
+	for i := range peers {
+		nodes[i] = startClusterNode(t, peers, fmt.Sprintf("node%d", i))
+	}
+
+	leader := waitForLeader(t, nodes)
+	require.NoError(t, leader.AdvanceBranch("main", "commit-before-failover"))
+
+	var leaderIdx int
+	survivors := make([]*Cluster, 0, 2)
+	for i, n := range nodes {
+		if n == leader {
+			leaderIdx = i
+			continue
+		}
+		survivors = append(survivors, n)
+	}
+	require.NoError(t, nodes[leaderIdx].Shutdown())
+	nodes[leaderIdx] = nil
+
+	newLeader := waitForLeader(t, survivors)
+	require.NotNil(t, newLeader)
+
+	for _, n := range survivors {
+		deadline := time.Now().Add(10 * time.Second)
+		var hash string
+		var known bool
+		for time.Now().Before(deadline) {
+			hash, known = n.CommitHash("main")
+			if known {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.True(t, known, "surviving node never learned branch 'main'")
+		assert.Equal(t, "commit-before-failover", hash)
+	}
+
+	require.NoError(t, newLeader.AdvanceBranch("main", "commit-after-failover"))
+	for _, n := range survivors {
+		deadline := time.Now().Add(10 * time.Second)
+		var hash string
+		for time.Now().Before(deadline) {
+			hash, _ = n.CommitHash("main")
+			if hash == "commit-after-failover" {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.Equal(t, "commit-after-failover", hash)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ioReadCloser is an alias kept local to this package purely so branchHeadFSM
+// doesn't need to import "io" just for its Restore signature.
+type ioReadCloser = io.ReadCloser
+
+func encodeBranchAdvance(adv branchAdvance) ([]byte, error) {
+	return json.Marshal(adv)
+}
+
+func decodeBranchAdvance(data []byte, adv *branchAdvance) error {
+	return json.Unmarshal(data, adv)
+}
+
+// branchHeadSnapshot is the raft.FSMSnapshot persisted when a log is
+// compacted; it just dumps the full branch-to-commit-hash map as JSON.
+type branchHeadSnapshot struct {
+	heads map[string]string
+}
+
+var _ raft.FSMSnapshot = (*branchHeadSnapshot)(nil)
+
+func (s *branchHeadSnapshot) Persist(sink raft.SnapshotSink) error {
+	data, err := json.Marshal(s.heads)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	if _, err := sink.Write(data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *branchHeadSnapshot) Release() {}
+
+func decodeBranchHeadSnapshot(rc io.ReadCloser) (map[string]string, error) {
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]string)
+	if len(data) == 0 {
+		return heads, nil
+	}
+	if err := json.Unmarshal(data, &heads); err != nil {
+		return nil, err
+	}
+	return heads, nil
+}
+
+func durationOrDefault(s *string, def time.Duration) time.Duration {
+	if s == nil {
+		return def
+	}
+	d, err := time.ParseDuration(*s)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// peer addresses are formatted "node-id@host:port"; the helpers below split
+// the two halves out for the parts of the cluster config (raft.ServerID,
+// raft.ServerAddress, the local listen address) that need just one.
+
+func nodeIDFromPeer(peer string) string {
+	if idx := strings.IndexByte(peer, '@'); idx >= 0 {
+		return peer[:idx]
+	}
+	return peer
+}
+
+func addrFromPeer(peer string) string {
+	if idx := strings.IndexByte(peer, '@'); idx >= 0 {
+		return peer[idx+1:]
+	}
+	return peer
+}
+
+// peerAddrForNode returns the listen address configured for nodeID among
+// peers, so this node knows what address to bind its raft transport to.
+func peerAddrForNode(peers []string, nodeID string) string {
+	for _, peer := range peers {
+		if nodeIDFromPeer(peer) == nodeID {
+			return addrFromPeer(peer)
+		}
+	}
+	return "0.0.0.0:0"
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LogLevel describes the severity of the log messages emitted by the server.
+type LogLevel string
+
+const (
+	LogLevel_Trace   LogLevel = "trace"
+	LogLevel_Debug   LogLevel = "debug"
+	LogLevel_Info    LogLevel = "info"
+	LogLevel_Warning LogLevel = "warning"
+	LogLevel_Error   LogLevel = "error"
+	LogLevel_Fatal   LogLevel = "fatal"
+	LogLevel_Panic   LogLevel = "panic"
+)
+
+func LogLevelFromString(s string) (LogLevel, bool) {
+	switch LogLevel(s) {
+	case LogLevel_Trace, LogLevel_Debug, LogLevel_Info, LogLevel_Warning, LogLevel_Error, LogLevel_Fatal, LogLevel_Panic:
+		return LogLevel(s), true
+	default:
+		return "", false
+	}
+}
+
+const (
+	DefaultHost      = "localhost"
+	DefaultPort      = 3306
+	DefaultUser      = "root"
+	DefaultPass      = ""
+	DefaultTimeout   = 30 * 1000
+	DefaultReadOnly  = false
+	DefaultLogLevel  = LogLevel_Info
+)
+
+// ServerConfig describes the configuration options accepted by the sql-server
+// command, whether they were supplied on the command line or via a YAML
+// config file.
+type ServerConfig interface {
+	Host() string
+	Port() int
+	User() string
+	Password() string
+	// Timeout returns the read and write timeout, in milliseconds, used for
+	// connections accepted by this server.
+	Timeout() uint64
+	ReadOnly() bool
+	LogLevel() LogLevel
+	// Telemetry returns the structured logging and tracing/metrics
+	// configuration for this server.
+	Telemetry() TelemetryConfig
+	// Auth returns the AuthProvider used to authenticate connecting clients.
+	Auth() AuthProvider
+	// Grants returns the GrantTable enforcing per-database/branch/table
+	// access control for connecting roles.
+	Grants() *GrantTable
+	// TLS returns the listener's TLS configuration, or nil if the listener
+	// accepts plain TCP connections.
+	TLS() *TLSConfig
+	// RequireSecureTransport reports whether plaintext connections must be
+	// rejected even when TLS is configured but not required by the client.
+	RequireSecureTransport() bool
+	// Cluster returns the replication group this server should join, or nil
+	// to run as a single standalone node.
+	Cluster() *ClusterConfig
+	// Limits returns the per-user connection/rate/row-scan limits enforced
+	// by the query governor.
+	Limits() LimitsConfig
+
+	withHost(host string) ServerConfig
+	withPort(port int) ServerConfig
+	withUser(user string) ServerConfig
+	withPassword(password string) ServerConfig
+	withTimeout(timeout uint64) ServerConfig
+	withReadOnly(readOnly bool) ServerConfig
+	withLogLevel(level LogLevel) ServerConfig
+	withTelemetry(cfg TelemetryConfig) ServerConfig
+	withAuth(auth AuthProvider) ServerConfig
+	withGrants(grants *GrantTable) ServerConfig
+	withTLS(certFile, keyFile, caFile string, requireClientCert bool) ServerConfig
+	withCluster(cfg *ClusterConfig) ServerConfig
+	withLimits(cfg LimitsConfig) ServerConfig
+}
+
+// commandLineServerConfig is the ServerConfig implementation backing both the
+// command line flags and the YAML config file: both parse into this struct.
+type commandLineServerConfig struct {
+	host                   string
+	port                   int
+	user                   string
+	password               string
+	timeout                uint64
+	readOnly               bool
+	logLevel               LogLevel
+	telemetry              TelemetryConfig
+	auth                   AuthProvider
+	grants                 *GrantTable
+	tls                    *TLSConfig
+	requireSecureTransport bool
+	cluster                *ClusterConfig
+	limits                 LimitsConfig
+}
+
+var _ ServerConfig = (*commandLineServerConfig)(nil)
+
+func DefaultServerConfig() *commandLineServerConfig {
+	cfg := &commandLineServerConfig{
+		host:      DefaultHost,
+		port:      DefaultPort,
+		user:      DefaultUser,
+		password:  DefaultPass,
+		timeout:   DefaultTimeout,
+		readOnly:  DefaultReadOnly,
+		logLevel:  DefaultLogLevel,
+		telemetry: DefaultTelemetryConfig(),
+	}
+	cfg.auth = NewStaticAuthProvider(cfg.user, cfg.password)
+	cfg.grants = NewGrantTable(nil)
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) Host() string                 { return cfg.host }
+func (cfg *commandLineServerConfig) Port() int                    { return cfg.port }
+func (cfg *commandLineServerConfig) User() string                 { return cfg.user }
+func (cfg *commandLineServerConfig) Password() string              { return cfg.password }
+func (cfg *commandLineServerConfig) Timeout() uint64               { return cfg.timeout }
+func (cfg *commandLineServerConfig) ReadOnly() bool                { return cfg.readOnly }
+func (cfg *commandLineServerConfig) LogLevel() LogLevel            { return cfg.logLevel }
+func (cfg *commandLineServerConfig) Telemetry() TelemetryConfig    { return cfg.telemetry }
+func (cfg *commandLineServerConfig) Auth() AuthProvider            { return cfg.auth }
+func (cfg *commandLineServerConfig) Grants() *GrantTable           { return cfg.grants }
+func (cfg *commandLineServerConfig) TLS() *TLSConfig               { return cfg.tls }
+func (cfg *commandLineServerConfig) RequireSecureTransport() bool  { return cfg.requireSecureTransport }
+func (cfg *commandLineServerConfig) Cluster() *ClusterConfig       { return cfg.cluster }
+func (cfg *commandLineServerConfig) Limits() LimitsConfig          { return cfg.limits }
+
+func (cfg *commandLineServerConfig) withHost(host string) ServerConfig {
+	cfg.host = host
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withPort(port int) ServerConfig {
+	cfg.port = port
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withUser(user string) ServerConfig {
+	cfg.user = user
+	cfg.refreshStaticAuth()
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withPassword(password string) ServerConfig {
+	cfg.password = password
+	cfg.refreshStaticAuth()
+	return cfg
+}
+
+// refreshStaticAuth keeps the default static AuthProvider consistent with
+// withUser/withPassword, so long as a caller hasn't overridden it with
+// withAuth (e.g. from the YAML auth: block).
+func (cfg *commandLineServerConfig) refreshStaticAuth() {
+	if _, ok := cfg.auth.(*staticAuthProvider); ok || cfg.auth == nil {
+		cfg.auth = NewStaticAuthProvider(cfg.user, cfg.password)
+	}
+}
+
+func (cfg *commandLineServerConfig) withTimeout(timeout uint64) ServerConfig {
+	cfg.timeout = timeout
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withReadOnly(readOnly bool) ServerConfig {
+	cfg.readOnly = readOnly
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withLogLevel(level LogLevel) ServerConfig {
+	cfg.logLevel = level
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withTelemetry(tCfg TelemetryConfig) ServerConfig {
+	cfg.telemetry = tCfg
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withAuth(auth AuthProvider) ServerConfig {
+	cfg.auth = auth
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withGrants(grants *GrantTable) ServerConfig {
+	cfg.grants = grants
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withTLS(certFile, keyFile, caFile string, requireClientCert bool) ServerConfig {
+	cfg.tls = &TLSConfig{
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		CAFile:            caFile,
+		RequireClientCert: requireClientCert,
+	}
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withCluster(clusterCfg *ClusterConfig) ServerConfig {
+	cfg.cluster = clusterCfg
+	return cfg
+}
+
+func (cfg *commandLineServerConfig) withLimits(limitsCfg LimitsConfig) ServerConfig {
+	cfg.limits = limitsCfg
+	return cfg
+}
+
+// ConfigInfo returns a short, human readable description of a ServerConfig,
+// suitable for use as a test name or log line.
+func ConfigInfo(config ServerConfig) string {
+	return fmt.Sprintf("host %s, port %d, user %s, readOnly %v, logLevel %v",
+		config.Host(), config.Port(), config.User(), config.ReadOnly(), config.LogLevel())
+}
+
+// ConnectionString returns a MySQL DSN prefix (without a database name) that
+// can be used to connect to a server running with the given config.
+func ConnectionString(config ServerConfig) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/", config.User(), config.Password(), config.Host(), config.Port())
+}
+
+// YAMLConfig is the root of the YAML document accepted by the --config flag.
+type YAMLConfig struct {
+	LogLevelStr *string              `yaml:"log_level,omitempty"`
+	BehaviorCfg yamlBehaviorConfig   `yaml:"behavior,omitempty"`
+	UserCfg     yamlUserConfig       `yaml:"user,omitempty"`
+	ListenerCfg yamlListenerConfig   `yaml:"listener,omitempty"`
+	TelemetryCfg *yamlTelemetryConfig `yaml:"telemetry,omitempty"`
+	AuthCfg      *AuthConfig          `yaml:"auth,omitempty"`
+	RBACCfg      *RBACConfig          `yaml:"rbac,omitempty"`
+	ClusterCfg   *ClusterConfig       `yaml:"cluster,omitempty"`
+	LimitsCfg    *LimitsConfig        `yaml:"limits,omitempty"`
+}
+
+type yamlBehaviorConfig struct {
+	ReadOnly               *bool `yaml:"read_only,omitempty"`
+	RequireSecureTransport *bool `yaml:"require_secure_transport,omitempty"`
+}
+
+type yamlUserConfig struct {
+	Name     *string `yaml:"name,omitempty"`
+	Password *string `yaml:"password,omitempty"`
+}
+
+type yamlListenerConfig struct {
+	Host               *string    `yaml:"host,omitempty"`
+	Port               *int       `yaml:"port,omitempty"`
+	ReadTimeoutMillis  *uint64    `yaml:"read_timeout_millis,omitempty"`
+	WriteTimeoutMillis *uint64    `yaml:"write_timeout_millis,omitempty"`
+	TLS                *TLSConfig `yaml:"tls,omitempty"`
+}
+
+// ParseYAMLConfig parses a YAML document into a ServerConfig, layering it on
+// top of the package defaults.
+func ParseYAMLConfig(data []byte) (ServerConfig, error) {
+	var yCfg YAMLConfig
+	if err := yaml.UnmarshalStrict(data, &yCfg); err != nil {
+		return nil, fmt.Errorf("failed to parse yaml config: %w", err)
+	}
+
+	cfg := DefaultServerConfig()
+
+	if yCfg.LogLevelStr != nil {
+		level, ok := LogLevelFromString(*yCfg.LogLevelStr)
+		if !ok {
+			return nil, fmt.Errorf("invalid log_level: %s", *yCfg.LogLevelStr)
+		}
+		cfg.withLogLevel(level)
+	}
+	if yCfg.BehaviorCfg.ReadOnly != nil {
+		cfg.withReadOnly(*yCfg.BehaviorCfg.ReadOnly)
+	}
+	if yCfg.BehaviorCfg.RequireSecureTransport != nil {
+		cfg.requireSecureTransport = *yCfg.BehaviorCfg.RequireSecureTransport
+	}
+	if yCfg.UserCfg.Name != nil {
+		cfg.withUser(*yCfg.UserCfg.Name)
+	}
+	if yCfg.UserCfg.Password != nil {
+		cfg.withPassword(*yCfg.UserCfg.Password)
+	}
+	if yCfg.ListenerCfg.Host != nil {
+		cfg.withHost(*yCfg.ListenerCfg.Host)
+	}
+	if yCfg.ListenerCfg.Port != nil {
+		cfg.withPort(*yCfg.ListenerCfg.Port)
+	}
+	if yCfg.ListenerCfg.ReadTimeoutMillis != nil {
+		cfg.withTimeout(*yCfg.ListenerCfg.ReadTimeoutMillis)
+	}
+	if yCfg.ListenerCfg.TLS != nil {
+		cfg.tls = yCfg.ListenerCfg.TLS
+	}
+
+	tCfg, err := parseTelemetryConfig(yCfg.TelemetryCfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.withTelemetry(tCfg)
+
+	auth, err := NewAuthProvider(yCfg.AuthCfg, cfg.user, cfg.password)
+	if err != nil {
+		return nil, err
+	}
+	cfg.withAuth(auth)
+
+	grants, err := NewGrantTableFromConfig(yCfg.RBACCfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.withGrants(grants)
+
+	if yCfg.ClusterCfg != nil {
+		cfg.withCluster(yCfg.ClusterCfg)
+	}
+
+	if yCfg.LimitsCfg != nil {
+		cfg.withLimits(*yCfg.LimitsCfg)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	gmssql "github.com/dolthub/go-mysql-server/sql"
+	"golang.org/x/time/rate"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/sqle"
+)
+
+const (
+	// ER_GOVERNOR_LIMIT_EXCEEDED is a dolt-specific error number in the
+	// range MySQL reserves for server-specific errors (>= 3000 is used by
+	// upstream MySQL/MariaDB extensions; dolt's own extensions live above
+	// 11000 to avoid ever colliding with a future upstream error).
+	ER_GOVERNOR_LIMIT_EXCEEDED = 11001
+)
+
+// LimitsConfig is the `limits:` YAML block controlling the per-user query
+// governor.
+type LimitsConfig struct {
+	MaxConnectionsPerUser int    `yaml:"max_connections_per_user,omitempty"`
+	MaxQueriesPerSecond   int    `yaml:"max_queries_per_second,omitempty"`
+	MaxRowsScanned        int64  `yaml:"max_rows_scanned,omitempty"`
+	QueryTimeout          string `yaml:"query_timeout,omitempty"`
+}
+
+// GovernorLimitError is returned when a query is rejected by the governor,
+// and maps to ER_GOVERNOR_LIMIT_EXCEEDED on the wire.
+type GovernorLimitError struct {
+	Reason string
+}
+
+func (e *GovernorLimitError) Error() string {
+	return fmt.Sprintf("query rejected by governor: %s", e.Reason)
+}
+
+func (e *GovernorLimitError) SQLState() string { return "HY000" }
+
+func (e *GovernorLimitError) Num() int { return ER_GOVERNOR_LIMIT_EXCEEDED }
+
+// perUserState is the governor's bookkeeping for a single user: its
+// concurrent connection count and its query-rate limiter.
+type perUserState struct {
+	mu          sync.Mutex
+	connections int
+	limiter     *rate.Limiter
+}
+
+// Governor enforces LimitsConfig across every connection and query handled
+// by the server.
+type Governor struct {
+	cfg LimitsConfig
+
+	mu    sync.Mutex
+	users map[string]*perUserState
+}
+
+func NewGovernor(cfg LimitsConfig) *Governor {
+	return &Governor{cfg: cfg, users: make(map[string]*perUserState)}
+}
+
+func (g *Governor) stateFor(user string) *perUserState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.users[user]
+	if !ok {
+		s = &perUserState{}
+		if g.cfg.MaxQueriesPerSecond > 0 {
+			s.limiter = rate.NewLimiter(rate.Limit(g.cfg.MaxQueriesPerSecond), g.cfg.MaxQueriesPerSecond)
+		}
+		g.users[user] = s
+	}
+	return s
+}
+
+// AcquireConnection reserves a connection slot for user, returning an error
+// if doing so would exceed MaxConnectionsPerUser.
+func (g *Governor) AcquireConnection(user string) error {
+	s := g.stateFor(user)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g.cfg.MaxConnectionsPerUser > 0 && s.connections >= g.cfg.MaxConnectionsPerUser {
+		return &GovernorLimitError{Reason: fmt.Sprintf("user '%s' has reached its max_connections_per_user limit of %d", user, g.cfg.MaxConnectionsPerUser)}
+	}
+	s.connections++
+	return nil
+}
+
+// ReleaseConnection frees the connection slot reserved by AcquireConnection.
+func (g *Governor) ReleaseConnection(user string) {
+	s := g.stateFor(user)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connections > 0 {
+		s.connections--
+	}
+}
+
+// AllowQuery reports whether user may run another query right now, given
+// MaxQueriesPerSecond.
+func (g *Governor) AllowQuery(user string) error {
+	s := g.stateFor(user)
+	if s.limiter != nil && !s.limiter.Allow() {
+		return &GovernorLimitError{Reason: fmt.Sprintf("user '%s' exceeded max_queries_per_second of %d", user, g.cfg.MaxQueriesPerSecond)}
+	}
+	return nil
+}
+
+// QueryTimeout returns the configured per-query timeout, or 0 if none is
+// set.
+func (g *Governor) QueryTimeout() time.Duration {
+	return durationOrDefault(nonEmptyOrNil(g.cfg.QueryTimeout), 0)
+}
+
+func nonEmptyOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// rowScanCapRowIter aborts iteration once more than maxRows rows have been
+// scanned, so a runaway SELECT can't exhaust server resources.
+type rowScanCapRowIter struct {
+	gmssql.RowIter
+	maxRows int64
+	scanned int64
+}
+
+func (r *rowScanCapRowIter) Next(ctx *gmssql.Context) (gmssql.Row, error) {
+	row, err := r.RowIter.Next(ctx)
+	if err != nil {
+		return row, err
+	}
+	r.scanned++
+	if r.maxRows > 0 && r.scanned > r.maxRows {
+		return nil, &GovernorLimitError{Reason: fmt.Sprintf("query scanned more than max_rows_scanned of %d rows", r.maxRows)}
+	}
+	return row, nil
+}
+
+// enforceLimits wraps engine so every query is checked against gov before
+// (and, for the row-scan cap, during) execution.
+func enforceLimits(engine *sqle.Engine, gov *Governor) *sqle.Engine {
+	if gov == nil {
+		return engine
+	}
+	engine.QueryHook = chainQueryHooks(engine.QueryHook, governorQueryHook(gov))
+	return engine
+}
+
+func governorQueryHook(gov *Governor) QueryHook {
+	return func(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error) {
+		user := ctx.Session.Client().User
+
+		if err := gov.AllowQuery(user); err != nil {
+			return nil, nil, err
+		}
+
+		var cancel context.CancelFunc
+		if timeout := gov.QueryTimeout(); timeout > 0 {
+			var timeoutCtx context.Context
+			timeoutCtx, cancel = context.WithTimeout(ctx.Context, timeout)
+			ctx.Context = timeoutCtx
+		}
+
+		schema, iter, err := next()
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return schema, iter, err
+		}
+
+		// next() only returns the RowIter; the caller iterates it after this
+		// hook has already returned, so cancel must fire when the iterator
+		// is closed or exhausted, not when this function returns.
+		if cancel != nil {
+			iter = &cancelingRowIter{RowIter: iter, cancel: cancel}
+		}
+		if gov.cfg.MaxRowsScanned > 0 {
+			iter = &rowScanCapRowIter{RowIter: iter, maxRows: gov.cfg.MaxRowsScanned}
+		}
+
+		return schema, iter, nil
+	}
+}
+
+// cancelingRowIter cancels the query_timeout context once when the wrapped
+// RowIter is closed or exhausted, freeing the timer goroutine without
+// cutting the query off before the caller has actually read its rows.
+type cancelingRowIter struct {
+	gmssql.RowIter
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (c *cancelingRowIter) Next(ctx *gmssql.Context) (gmssql.Row, error) {
+	row, err := c.RowIter.Next(ctx)
+	if err != nil {
+		c.finish()
+	}
+	return row, err
+}
+
+func (c *cancelingRowIter) Close(ctx *gmssql.Context) error {
+	c.finish()
+	return c.RowIter.Close(ctx)
+}
+
+func (c *cancelingRowIter) finish() {
+	c.once.Do(c.cancel)
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/dtestutils"
+)
+
+func TestGovernorMaxConnectionsPerUser(t *testing.T) {
+	gov := NewGovernor(LimitsConfig{MaxConnectionsPerUser: 1})
+
+	require.NoError(t, gov.AcquireConnection("alice"))
+	err := gov.AcquireConnection("alice")
+	require.Error(t, err)
+	assert.Equal(t, ER_GOVERNOR_LIMIT_EXCEEDED, err.(*GovernorLimitError).Num())
+
+	gov.ReleaseConnection("alice")
+	assert.NoError(t, gov.AcquireConnection("alice"))
+}
+
+func TestGovernorMaxQueriesPerSecond(t *testing.T) {
+	gov := NewGovernor(LimitsConfig{MaxQueriesPerSecond: 1})
+
+	require.NoError(t, gov.AllowQuery("alice"))
+	err := gov.AllowQuery("alice")
+	assert.Error(t, err)
+}
+
+func TestServerRowScanCap(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15700).
+		withLimits(LimitsConfig{MaxRowsScanned: 1})
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	err := sc.WaitForStart()
+	require.NoError(t, err)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var people []testPerson
+	_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+	assert.Error(t, err)
+}
+
+// TestServerQueryTimeoutAllowsNormalQueries guards against governorQueryHook
+// canceling its query_timeout context as soon as the hook returns, rather
+// than when the caller has finished reading the RowIter: with that bug, a
+// configured query_timeout broke every query immediately regardless of how
+// long it actually took to run.
+func TestServerQueryTimeoutAllowsNormalQueries(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15701).
+		withLimits(LimitsConfig{QueryTimeout: "1h"})
+	startTestServer(t, env, serverConfig)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var people []testPerson
+	_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+	require.NoError(t, err)
+	assert.NotEmpty(t, people)
+}
+
+// TestServerQueryTimeoutCancelsSlowQueries is the companion case: a query
+// that genuinely outlives query_timeout is canceled.
+func TestServerQueryTimeoutCancelsSlowQueries(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15702).
+		withLimits(LimitsConfig{QueryTimeout: "50ms"})
+	startTestServer(t, env, serverConfig)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var result []int
+	_, err = conn.NewSession(nil).Select("SLEEP(1)").LoadContext(context.Background(), &result)
+	assert.Error(t, err)
+}
+
+// TestServerMaxQueriesPerSecondThrottlesConnections runs the requested
+// end-to-end scenario: several dbr sessions authenticated as the same user
+// fire queries concurrently, and once max_queries_per_second is exceeded
+// some of them are rejected by the governor.
+func TestServerMaxQueriesPerSecondThrottlesConnections(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15703).
+		withLimits(LimitsConfig{MaxQueriesPerSecond: 1})
+	startTestServer(t, env, serverConfig)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	const sessions = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var throttled int
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var people []testPerson
+			_, err := conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+			if err != nil {
+				mu.Lock()
+				throttled++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Greater(t, throttled, 0, "expected at least one of %d concurrent queries to be throttled", sessions)
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"testing"
+
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/dtestutils"
+)
+
+func TestGrantTableAuthorized(t *testing.T) {
+	grants := NewGrantTable([]Grant{
+		{Role: "alice", Privilege: Privilege_Select, Scope: Scope{Database: "dolt", Branch: "main", Table: "*"}},
+		{Role: "bob", Privilege: Privilege_Write, Scope: Scope{Database: "dolt", Branch: "feature/*", Table: "*"}},
+	})
+
+	assert.True(t, grants.Authorized("alice", Privilege_Select, "dolt", "main", "people"))
+	assert.False(t, grants.Authorized("alice", Privilege_Write, "dolt", "main", "people"))
+	assert.False(t, grants.Authorized("alice", Privilege_Select, "dolt", "feature/x", "people"))
+
+	assert.True(t, grants.Authorized("bob", Privilege_Write, "dolt", "feature/x", "people"))
+	assert.False(t, grants.Authorized("bob", Privilege_Write, "dolt", "main", "people"))
+}
+
+func TestGrantTableIsEmpty(t *testing.T) {
+	var nilTable *GrantTable
+	assert.True(t, nilTable.IsEmpty())
+	assert.True(t, NewGrantTable(nil).IsEmpty())
+
+	grants := NewGrantTable([]Grant{
+		{Role: "alice", Privilege: Privilege_Select, Scope: Scope{Database: "*", Branch: "*", Table: "*"}},
+	})
+	assert.False(t, grants.IsEmpty())
+}
+
+func TestGrantTableRevoke(t *testing.T) {
+	g := Grant{Role: "alice", Privilege: Privilege_Select, Scope: Scope{Database: "dolt", Branch: "main", Table: "*"}}
+	grants := NewGrantTable([]Grant{g})
+	assert.True(t, grants.Authorized("alice", Privilege_Select, "dolt", "main", "people"))
+
+	grants.Revoke(g.Role, g.Privilege, g.Scope)
+	assert.False(t, grants.Authorized("alice", Privilege_Select, "dolt", "main", "people"))
+}
+
+func TestGrantTableAdminSatisfiesAnyPrivilege(t *testing.T) {
+	grants := NewGrantTable([]Grant{
+		{Role: "root", Privilege: Privilege_Admin, Scope: Scope{Database: "*", Branch: "*", Table: "*"}},
+	})
+
+	assert.True(t, grants.Authorized("root", Privilege_Select, "dolt", "main", "people"))
+	assert.True(t, grants.Authorized("root", Privilege_Write, "dolt", "feature/x", "people"))
+}
+
+func TestQueryTables(t *testing.T) {
+	assert.Equal(t, []string{"people"}, queryTables("select * from people"))
+	assert.Equal(t, []string{"people"}, queryTables("SELECT * FROM `people` WHERE age > 21"))
+	assert.Equal(t, []string{"people"}, queryTables("insert into people values (1)"))
+	assert.Equal(t, []string{"people"}, queryTables("update people set age = 22"))
+	assert.Equal(t, []string{"people", "pets"}, queryTables("select * from people join pets on people.id = pets.owner_id"))
+	assert.Equal(t, []string{"people", "secret_table"}, queryTables("select * from people, secret_table"))
+	assert.Equal(t, []string{"secret"}, queryTables("select * from mydb.secret"))
+	assert.Equal(t, []string{"secret"}, queryTables("select * from `mydb`.`secret`"))
+	assert.Empty(t, queryTables("show tables"))
+	assert.Empty(t, queryTables("set GLOBAL dolt_default_branch = 'main'"))
+}
+
+// TestServerRBACTableScope exercises grantCheckHook end-to-end through a
+// running server: a grant scoped to one table must not authorize queries
+// against a different table, and must authorize queries against the table
+// it names.
+func TestServerRBACTableScope(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+
+	t.Run("grant matches queried table", func(t *testing.T) {
+		grants := NewGrantTable([]Grant{
+			{Role: DefaultUser, Privilege: Privilege_Select, Scope: Scope{Database: "*", Branch: "*", Table: "people"}},
+		})
+		serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15520).withGrants(grants)
+		startTestServer(t, env, serverConfig)
+
+		conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var people []testPerson
+		_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+		assert.NoError(t, err)
+	})
+
+	t.Run("grant scoped to a different table does not match", func(t *testing.T) {
+		grants := NewGrantTable([]Grant{
+			{Role: DefaultUser, Privilege: Privilege_Select, Scope: Scope{Database: "*", Branch: "*", Table: "other_table"}},
+		})
+		serverConfig := DefaultServerConfig().withLogLevel(LogLevel_Fatal).withPort(15521).withGrants(grants)
+		startTestServer(t, env, serverConfig)
+
+		conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var people []testPerson
+		_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/dolthub/go-mysql-server/server"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/sirupsen/logrus"
+	"vitess.io/vitess/go/mysql"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/env"
+	"github.com/dolthub/dolt/go/libraries/doltcore/sqle"
+)
+
+// newSQLEngine constructs the go-mysql-server engine backed by the given
+// dolt environment.
+func newSQLEngine(ctx context.Context, dEnv *env.DoltEnv, readOnly bool) (*sqle.Engine, func(context.Context) *sql.Context, error) {
+	engine, err := sqle.NewEngine(ctx, dEnv, readOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqlCtxFactory := func(ctx context.Context) *sql.Context {
+		return sql.NewContext(ctx)
+	}
+
+	return engine, sqlCtxFactory, nil
+}
+
+// startServer parses the command line (or --config YAML) arguments for the
+// sql-server command and, if they're valid, hands off to Serve.
+func startServer(ctx context.Context, version, name string, args []string, dEnv *env.DoltEnv, sc *ServerController) (*server.Server, error) {
+	config, err := parseArgs(name, args, dEnv)
+	if err != nil {
+		sc.setStartError(err)
+		return nil, err
+	}
+
+	return Serve(ctx, version, config, sc, dEnv)
+}
+
+func parseArgs(name string, args []string, dEnv *env.DoltEnv) (ServerConfig, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	host := fs.String("H", DefaultHost, "host to bind to")
+	port := fs.Int("P", DefaultPort, "port to bind to")
+	user := fs.String("u", DefaultUser, "user to authenticate as")
+	password := fs.String("p", DefaultPass, "password to authenticate with")
+	timeout := fs.Uint64("t", DefaultTimeout, "connection read/write timeout, in milliseconds")
+	logLevel := fs.String("l", string(DefaultLogLevel), "log level")
+	readOnly := fs.Bool("r", false, "run in read only mode")
+	configFile := fs.String("config", "", "path to a YAML config file")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		data, err := dEnv.FS.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file '%s': %w", *configFile, err)
+		}
+		return ParseYAMLConfig(data)
+	}
+
+	level, ok := LogLevelFromString(*logLevel)
+	if !ok {
+		return nil, fmt.Errorf("invalid log level: %s", *logLevel)
+	}
+
+	if err := validateHost(*host); err != nil {
+		return nil, err
+	}
+	if err := validatePort(*port); err != nil {
+		return nil, err
+	}
+	if *user == "" {
+		return nil, fmt.Errorf("user must not be empty")
+	}
+
+	config := DefaultServerConfig().
+		withHost(*host).
+		withPort(*port).
+		withUser(*user).
+		withPassword(*password).
+		withTimeout(*timeout).
+		withReadOnly(*readOnly).
+		withLogLevel(level)
+
+	return config, nil
+}
+
+func validateHost(host string) error {
+	if host == "localhost" || host == "0.0.0.0" || host == "::1" {
+		return nil
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid host: %s", host)
+	}
+	return nil
+}
+
+func validatePort(port int) error {
+	if port < 1024 || port > 65535 {
+		return fmt.Errorf("invalid port: %d, must be between 1024 and 65535", port)
+	}
+	return nil
+}
+
+// Serve starts a sql-server for the given dolt environment and blocks until
+// it is stopped via the ServerController, returning the underlying
+// go-mysql-server Server so tests and callers can introspect it.
+func Serve(ctx context.Context, version string, config ServerConfig, sc *ServerController, dEnv *env.DoltEnv) (*server.Server, error) {
+	logger := newLogger(config.LogLevel(), config.Telemetry())
+
+	if config.RequireSecureTransport() && config.TLS() == nil {
+		err := fmt.Errorf("require_secure_transport is set but no listener.tls: block was configured")
+		sc.setStartError(err)
+		return nil, err
+	}
+
+	shutdownTelemetry, err := setupTelemetry(ctx, config.Telemetry())
+	if err != nil {
+		sc.setStartError(err)
+		return nil, err
+	}
+	defer shutdownTelemetry(ctx)
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(config.Host(), strconv.Itoa(config.Port())))
+	if err != nil {
+		err = fmt.Errorf("Port %d already in use.", config.Port())
+		sc.setStartError(err)
+		return nil, err
+	}
+
+	certUsers := newCertUserRegistry()
+	if tlsCfg, tErr := BuildTLSConfig(config.TLS()); tErr != nil {
+		listener.Close()
+		sc.setStartError(tErr)
+		return nil, tErr
+	} else if tlsCfg != nil {
+		listener = newSecureTransportListener(tls.NewListener(listener, tlsCfg), certUsers)
+	}
+
+	var cluster *Cluster
+	if clusterCfg := config.Cluster(); clusterCfg != nil {
+		cluster, err = NewCluster(*clusterCfg)
+		if err != nil {
+			listener.Close()
+			sc.setStartError(err)
+			return nil, err
+		}
+	}
+
+	srv, err := newMySQLServer(ctx, config, dEnv, listener, logger, cluster, certUsers)
+	if err != nil {
+		listener.Close()
+		sc.setStartError(err)
+		return nil, err
+	}
+
+	go func() {
+		<-sc.registerCloseChan()
+		err := srv.Close()
+		if cluster != nil {
+			if cErr := cluster.Shutdown(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+		sc.setCloseError(err)
+	}()
+
+	sc.setStartError(nil)
+
+	startErr := srv.Start()
+
+	return srv, startErr
+}
+
+// newMySQLServer builds the go-mysql-server server for the given dolt
+// environment, instrumenting it with the tracing/metrics middleware
+// described by config.Telemetry().
+func newMySQLServer(ctx context.Context, config ServerConfig, dEnv *env.DoltEnv, listener net.Listener, logger *logrus.Logger, cluster *Cluster, certUsers *certUserRegistry) (*server.Server, error) {
+	engine, sqlCtxFactory, err := newSQLEngine(ctx, dEnv, config.ReadOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	gov := NewGovernor(config.Limits())
+
+	metrics, err := setupMetrics(config.Telemetry())
+	if err != nil {
+		return nil, err
+	}
+
+	engine = instrumentEngine(engine, config.Telemetry(), metrics)
+	engine = enforceGrants(engine, config.Grants())
+	engine = enforceClusterLeadership(engine, cluster)
+	engine = enforceLimits(engine, gov)
+
+	srvCfg := server.Config{
+		Protocol:        "tcp",
+		Address:         net.JoinHostPort(config.Host(), strconv.Itoa(config.Port())),
+		ConnReadTimeout: config.Timeout(),
+		ConnWriteTimeout: config.Timeout(),
+		Auth:           newAuthServer(config.Auth(), certUsers, gov, metrics),
+		ConnClosedHandler: func(c *mysql.Conn) {
+			gov.ReleaseConnection(c.User)
+			metrics.ConnectionClosed()
+		},
+	}
+
+	return server.NewServer(srvCfg, engine, sqlCtxFactory, listener)
+}
+
+func newLogger(level LogLevel, tCfg TelemetryConfig) *logrus.Logger {
+	logger := logrus.New()
+	if tCfg.Format() == LogFormat_JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	parsed, err := logrus.ParseLevel(string(level))
+	if err != nil {
+		parsed = logrus.InfoLevel
+	}
+	logger.SetLevel(parsed)
+
+	return logger
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import "sync"
+
+// ServerController is used by callers to start and stop a sql-server running
+// in another goroutine, and to be notified when it has finished starting up
+// or shutting down.
+type ServerController struct {
+	mu        sync.Mutex
+	startErr  error
+	closeErr  error
+	startCh   chan struct{}
+	closeCh   chan struct{}
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+}
+
+func CreateServerController() *ServerController {
+	return &ServerController{
+		startCh: make(chan struct{}),
+		closeCh: make(chan struct{}),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// WaitForStart blocks until the server has either finished starting up or
+// failed to do so, returning the error from the latter case.
+func (sc *ServerController) WaitForStart() error {
+	<-sc.startCh
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.startErr
+}
+
+// WaitForClose blocks until the server has shut down, returning any error
+// encountered while doing so.
+func (sc *ServerController) WaitForClose() error {
+	<-sc.closeCh
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.closeErr
+}
+
+// StopServer signals the running server that it should shut down.
+func (sc *ServerController) StopServer() {
+	sc.stopOnce.Do(func() {
+		close(sc.stopCh)
+	})
+}
+
+func (sc *ServerController) registerCloseChan() <-chan struct{} {
+	return sc.stopCh
+}
+
+func (sc *ServerController) setStartError(err error) {
+	sc.mu.Lock()
+	sc.startErr = err
+	sc.mu.Unlock()
+	close(sc.startCh)
+}
+
+func (sc *ServerController) setCloseError(err error) {
+	sc.mu.Lock()
+	sc.closeErr = err
+	sc.mu.Unlock()
+	close(sc.closeCh)
+}
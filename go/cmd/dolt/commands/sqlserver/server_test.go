@@ -46,6 +46,26 @@ var (
 	rob  = testPerson{"Rob Robertson", 21, false, ""}
 )
 
+// startTestServer starts Serve for config against dEnv in a background
+// goroutine, waits for it to be ready to accept connections, and registers a
+// cleanup that stops it when the test completes.
+func startTestServer(t *testing.T, dEnv *env.DoltEnv, config ServerConfig) *ServerController {
+	t.Helper()
+
+	sc := CreateServerController()
+	go func() {
+		_, _ = Serve(context.Background(), "", config, sc, dEnv)
+	}()
+	require.NoError(t, sc.WaitForStart())
+
+	t.Cleanup(func() {
+		sc.StopServer()
+		sc.WaitForClose()
+	})
+
+	return sc
+}
+
 func TestServerArgs(t *testing.T) {
 	serverController := CreateServerController()
 	go func() {
@@ -86,6 +106,20 @@ listener:
     port: 15200
     read_timeout_millis: 5000
     write_timeout_millis: 5000
+
+telemetry:
+    service_name: dolt-yaml-test
+
+auth:
+    provider: static
+
+limits:
+    max_connections_per_user: 10
+
+rbac:
+    grants:
+        - role: username
+          privilege: ADMIN
 `
 	serverController := CreateServerController()
 	go func() {
@@ -0,0 +1,426 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	gmssql "github.com/dolthub/go-mysql-server/sql"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/sqle"
+)
+
+// instrumentationName identifies this package to OpenTelemetry as the
+// source of the spans and instruments it creates.
+const instrumentationName = "github.com/dolthub/dolt/go/cmd/dolt/commands/sqlserver"
+
+// LogFormat selects how log lines written by the server are encoded.
+type LogFormat string
+
+const (
+	LogFormat_Text LogFormat = "text"
+	LogFormat_JSON LogFormat = "json"
+)
+
+const (
+	DefaultLogFormat            = LogFormat_Text
+	DefaultTracingEnabled       = false
+	DefaultTracingSamplingRatio = 1.0
+	DefaultMetricsEnabled       = false
+	DefaultTelemetryServiceName = "dolt-sql-server"
+)
+
+// TelemetryConfig describes the structured logging and OpenTelemetry
+// tracing/metrics options accepted under the `telemetry:` YAML block (and
+// its command line equivalents, once added).
+type TelemetryConfig interface {
+	Format() LogFormat
+	TracingEnabled() bool
+	MetricsEnabled() bool
+	// OTLPEndpoint is the host:port of the OTLP collector that spans are
+	// exported to. Metrics are not exported to it; they are recorded against
+	// the global MeterProvider.
+	OTLPEndpoint() string
+	// SamplingRatio is the fraction, in [0,1], of traces that are sampled.
+	SamplingRatio() float64
+	ServiceName() string
+	ResourceAttributes() map[string]string
+}
+
+type telemetryConfig struct {
+	format             LogFormat
+	tracingEnabled     bool
+	metricsEnabled     bool
+	otlpEndpoint       string
+	samplingRatio      float64
+	serviceName        string
+	resourceAttributes map[string]string
+}
+
+var _ TelemetryConfig = telemetryConfig{}
+
+func DefaultTelemetryConfig() TelemetryConfig {
+	return telemetryConfig{
+		format:         DefaultLogFormat,
+		tracingEnabled: DefaultTracingEnabled,
+		metricsEnabled: DefaultMetricsEnabled,
+		samplingRatio:  DefaultTracingSamplingRatio,
+		serviceName:    DefaultTelemetryServiceName,
+	}
+}
+
+func (c telemetryConfig) Format() LogFormat                     { return c.format }
+func (c telemetryConfig) TracingEnabled() bool                  { return c.tracingEnabled }
+func (c telemetryConfig) MetricsEnabled() bool                  { return c.metricsEnabled }
+func (c telemetryConfig) OTLPEndpoint() string                  { return c.otlpEndpoint }
+func (c telemetryConfig) SamplingRatio() float64                { return c.samplingRatio }
+func (c telemetryConfig) ServiceName() string                   { return c.serviceName }
+func (c telemetryConfig) ResourceAttributes() map[string]string { return c.resourceAttributes }
+
+// yamlTelemetryConfig is the YAML shape of the `telemetry:` block.
+type yamlTelemetryConfig struct {
+	LogFormat          *string           `yaml:"log_format,omitempty"`
+	Tracing            *bool             `yaml:"tracing,omitempty"`
+	Metrics            *bool             `yaml:"metrics,omitempty"`
+	OTLPEndpoint       *string           `yaml:"otlp_endpoint,omitempty"`
+	SamplingRatio      *float64          `yaml:"sampling_ratio,omitempty"`
+	ServiceName        *string           `yaml:"service_name,omitempty"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes,omitempty"`
+}
+
+func parseTelemetryConfig(y *yamlTelemetryConfig) (TelemetryConfig, error) {
+	cfg := DefaultTelemetryConfig().(telemetryConfig)
+	if y == nil {
+		return cfg, nil
+	}
+
+	if y.LogFormat != nil {
+		switch LogFormat(*y.LogFormat) {
+		case LogFormat_Text, LogFormat_JSON:
+			cfg.format = LogFormat(*y.LogFormat)
+		default:
+			return nil, fmt.Errorf("invalid telemetry log_format: %s", *y.LogFormat)
+		}
+	}
+	if y.Tracing != nil {
+		cfg.tracingEnabled = *y.Tracing
+	}
+	if y.Metrics != nil {
+		cfg.metricsEnabled = *y.Metrics
+	}
+	if y.OTLPEndpoint != nil {
+		cfg.otlpEndpoint = *y.OTLPEndpoint
+	}
+	if y.SamplingRatio != nil {
+		if *y.SamplingRatio < 0 || *y.SamplingRatio > 1 {
+			return nil, fmt.Errorf("telemetry sampling_ratio must be between 0 and 1, got %f", *y.SamplingRatio)
+		}
+		cfg.samplingRatio = *y.SamplingRatio
+	}
+	if y.ServiceName != nil {
+		cfg.serviceName = *y.ServiceName
+	}
+	if y.ResourceAttributes != nil {
+		cfg.resourceAttributes = y.ResourceAttributes
+	}
+
+	return cfg, nil
+}
+
+// setupTelemetry installs a global OpenTelemetry tracer provider for the
+// server and returns a shutdown function that should be deferred by the
+// caller. When tracing is disabled, no tracer provider is installed and the
+// returned shutdown function is a no-op.
+func setupTelemetry(ctx context.Context, cfg TelemetryConfig) (func(context.Context) error, error) {
+	if !cfg.TracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(cfg.ServiceName())}
+	for k, v := range cfg.ResourceAttributes() {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build otel resource: %w", err)
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint()), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.SamplingRatio())),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+var tracer = otel.Tracer(instrumentationName)
+
+// QueryHook wraps the execution of a single query. Hooks are composed with
+// chainQueryHooks so that multiple independent concerns (tracing, RBAC
+// enforcement, rate limiting, ...) can each contribute one without knowing
+// about the others.
+type QueryHook func(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error)
+
+// chainQueryHooks returns a QueryHook that runs outer around inner, calling
+// inner's next() to reach the rest of the chain. A nil outer or inner is
+// treated as a pass-through.
+func chainQueryHooks(outer, inner QueryHook) QueryHook {
+	if outer == nil {
+		return inner
+	}
+	if inner == nil {
+		return outer
+	}
+	return func(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error) {
+		return outer(ctx, query, func() (gmssql.Schema, gmssql.RowIter, error) {
+			return inner(ctx, query, next)
+		})
+	}
+}
+
+// Metrics holds the OpenTelemetry instruments backing the Prometheus-style
+// metrics described by a `telemetry:` block with metrics enabled: the number
+// of currently open connections, per-query latency, and per-branch query
+// counts.
+type Metrics struct {
+	queryDuration     otelmetric.Float64Histogram
+	activeConnections otelmetric.Int64UpDownCounter
+	branchQueries     otelmetric.Int64Counter
+}
+
+// setupMetrics builds the instruments described by cfg against the global
+// MeterProvider, returning a nil *Metrics when metrics are disabled.
+func setupMetrics(cfg TelemetryConfig) (*Metrics, error) {
+	if !cfg.MetricsEnabled() {
+		return nil, nil
+	}
+
+	meter := otel.Meter(instrumentationName)
+
+	queryDuration, err := meter.Float64Histogram("dolt.query.duration_ms",
+		otelmetric.WithDescription("Duration of each query executed by the server, in milliseconds"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dolt.query.duration_ms instrument: %w", err)
+	}
+
+	activeConnections, err := meter.Int64UpDownCounter("dolt.connections.active",
+		otelmetric.WithDescription("Number of client connections currently open"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dolt.connections.active instrument: %w", err)
+	}
+
+	branchQueries, err := meter.Int64Counter("dolt.branch.queries",
+		otelmetric.WithDescription("Number of queries run against each branch"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dolt.branch.queries instrument: %w", err)
+	}
+
+	return &Metrics{queryDuration: queryDuration, activeConnections: activeConnections, branchQueries: branchQueries}, nil
+}
+
+// ConnectionOpened records that a new client connection was accepted. A nil
+// *Metrics (metrics disabled) is a no-op.
+func (m *Metrics) ConnectionOpened() {
+	if m != nil {
+		m.activeConnections.Add(context.Background(), 1)
+	}
+}
+
+// ConnectionClosed records that a client connection was closed. A nil
+// *Metrics (metrics disabled) is a no-op.
+func (m *Metrics) ConnectionClosed() {
+	if m != nil {
+		m.activeConnections.Add(context.Background(), -1)
+	}
+}
+
+// metricsQueryHook records dolt.query.duration_ms and dolt.branch.queries for
+// every query, independent of whether tracing is enabled.
+func metricsQueryHook(m *Metrics) QueryHook {
+	return func(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error) {
+		start := time.Now()
+		schema, iter, err := next()
+
+		attrs := otelmetric.WithAttributes(attribute.String("branch", currentBranch(ctx)))
+		m.queryDuration.Record(ctx.Context, float64(time.Since(start).Milliseconds()), attrs)
+		m.branchQueries.Add(ctx.Context, 1, attrs)
+
+		return schema, iter, err
+	}
+}
+
+// instrumentEngine wraps engine so that, as described by cfg, every query it
+// runs emits a span carrying the statement kind, database, branch, duration
+// and row count, and records the query duration and per-branch query count
+// metrics (attributed by branch only). A nil metrics (metrics disabled) skips
+// the metrics hook.
+func instrumentEngine(engine *sqle.Engine, cfg TelemetryConfig, metrics *Metrics) *sqle.Engine {
+	if cfg.TracingEnabled() {
+		engine.QueryHook = chainQueryHooks(engine.QueryHook, tracingQueryHook)
+	}
+	if metrics != nil {
+		engine.QueryHook = chainQueryHooks(engine.QueryHook, metricsQueryHook(metrics))
+	}
+	return engine
+}
+
+// tracingQueryHook is invoked by the engine around every query it executes.
+// It records a span describing the statement, the time taken to build its
+// RowIter (dolt.duration_ms) and any error doing so and, once the returned
+// RowIter has been fully consumed or closed, its row count. Errors returned
+// while iterating the rows are not recorded on the span. The span is
+// deliberately not ended until then: ending it as soon as
+// this hook returns (before the caller iterates the rows) would finalize it
+// too early to record dolt.rows_returned.
+func tracingQueryHook(ctx *gmssql.Context, query string, next func() (gmssql.Schema, gmssql.RowIter, error)) (gmssql.Schema, gmssql.RowIter, error) {
+	spanCtx, span := tracer.Start(ctx.Context, "dolt.query",
+		trace.WithAttributes(
+			attribute.String("dolt.statement_kind", statementKind(query)),
+			attribute.String("dolt.database", ctx.GetCurrentDatabase()),
+			attribute.String("dolt.branch", currentBranch(ctx)),
+		),
+	)
+	ctx.Context = spanCtx
+
+	if opName := doltOperationName(query); opName != "" {
+		opCtx, opSpan := recordDoltOperation(ctx.Context, opName)
+		defer opSpan.End()
+		ctx.Context = opCtx
+	}
+
+	start := time.Now()
+	schema, iter, err := next()
+	span.SetAttributes(attribute.Int64("dolt.duration_ms", time.Since(start).Milliseconds()))
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return schema, iter, err
+	}
+
+	return schema, &countingRowIter{RowIter: iter, span: span}, nil
+}
+
+// countingRowIter wraps a sql.RowIter so the number of rows returned by a
+// query can be attached to its span, and the span ended, once iteration
+// completes or the iterator is closed.
+type countingRowIter struct {
+	gmssql.RowIter
+	span  trace.Span
+	count int64
+	once  sync.Once
+}
+
+func (c *countingRowIter) Next(ctx *gmssql.Context) (gmssql.Row, error) {
+	row, err := c.RowIter.Next(ctx)
+	if err == nil {
+		c.count++
+	} else {
+		c.finish()
+	}
+	return row, err
+}
+
+func (c *countingRowIter) Close(ctx *gmssql.Context) error {
+	c.finish()
+	return c.RowIter.Close(ctx)
+}
+
+func (c *countingRowIter) finish() {
+	c.once.Do(func() {
+		c.span.SetAttributes(attribute.Int64("dolt.rows_returned", c.count))
+		c.span.End()
+	})
+}
+
+func statementKind(query string) string {
+	for i := 0; i < len(query); i++ {
+		if query[i] == ' ' {
+			return query[:i]
+		}
+	}
+	return query
+}
+
+// currentBranch returns the branch the session backing ctx is currently
+// checked out to, or the empty string if that cannot be determined.
+func currentBranch(ctx *gmssql.Context) string {
+	if b, ok := ctx.Session.GetSessionVariable(ctx, "active_branch"); ok == nil {
+		if s, ok := b.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// currentCommitHash returns the commit hash at the head of the session's
+// current database, via its "<database>_head" session variable, or the
+// empty string if that cannot be determined.
+func currentCommitHash(ctx *gmssql.Context) string {
+	database := ctx.GetCurrentDatabase()
+	if database == "" {
+		return ""
+	}
+	if h, ok := ctx.Session.GetSessionVariable(ctx, database+"_head"); ok == nil {
+		if s, ok := h.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// doltOperationName returns the name of the Dolt porcelain operation (e.g.
+// "checkout", "merge", "commit") a query invokes via its dolt_* table
+// function, or "" if the query isn't one of those.
+func doltOperationName(query string) string {
+	lower := strings.ToLower(query)
+	switch {
+	case strings.Contains(lower, "dolt_checkout"):
+		return "checkout"
+	case strings.Contains(lower, "dolt_merge"):
+		return "merge"
+	case strings.Contains(lower, "dolt_commit"):
+		return "commit"
+	default:
+		return ""
+	}
+}
+
+// recordDoltOperation emits a child span for a Dolt porcelain operation
+// (checkout, merge, commit, ...) executed as part of a query.
+func recordDoltOperation(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	spanCtx, span := tracer.Start(ctx, "dolt."+name, trace.WithAttributes(attrs...))
+	return spanCtx, span
+}
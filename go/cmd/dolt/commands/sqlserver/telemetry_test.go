@@ -0,0 +1,136 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"testing"
+
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/context"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/dtestutils"
+)
+
+func TestServerQueryTelemetry(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevTp := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prevTp)
+
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15500).
+		withTelemetry(telemetryConfig{
+			format:         LogFormat_Text,
+			tracingEnabled: true,
+			samplingRatio:  1.0,
+			serviceName:    "dolt-sql-server-test",
+		})
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	err := sc.WaitForStart()
+	require.NoError(t, err)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sess := conn.NewSession(nil)
+	var people []testPerson
+	_, err = sess.Select("*").From("people").LoadContext(context.Background(), &people)
+	require.NoError(t, err)
+
+	sc.StopServer()
+	err = sc.WaitForClose()
+	assert.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.NotEmpty(t, spans)
+	assert.Equal(t, "dolt.query", spans[0].Name)
+
+	var rowsReturned int64 = -1
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == attribute.Key("dolt.rows_returned") {
+			rowsReturned = attr.Value.AsInt64()
+		}
+	}
+	assert.EqualValues(t, len(people), rowsReturned)
+}
+
+func TestServerQueryMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	prevMp := otel.GetMeterProvider()
+	otel.SetMeterProvider(mp)
+	defer otel.SetMeterProvider(prevMp)
+
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15501).
+		withTelemetry(telemetryConfig{
+			format:         LogFormat_Text,
+			metricsEnabled: true,
+		})
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	err := sc.WaitForStart()
+	require.NoError(t, err)
+
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &[]testPerson{})
+	require.NoError(t, err)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	var sawDuration, sawBranchQueries, sawActiveConnections bool
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch m.Name {
+			case "dolt.query.duration_ms":
+				sawDuration = true
+			case "dolt.branch.queries":
+				sawBranchQueries = true
+			case "dolt.connections.active":
+				sawActiveConnections = true
+			}
+		}
+	}
+	assert.True(t, sawDuration, "expected a dolt.query.duration_ms metric")
+	assert.True(t, sawBranchQueries, "expected a dolt.branch.queries metric")
+	assert.True(t, sawActiveConnections, "expected a dolt.connections.active metric")
+}
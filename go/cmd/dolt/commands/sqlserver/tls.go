@@ -0,0 +1,181 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// TLSConfig describes the `listener.tls:` YAML block (and its command line
+// equivalent, withTLS) controlling whether the MySQL listener negotiates
+// TLS, and whether it requires a client certificate.
+type TLSConfig struct {
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	CAFile            string `yaml:"ca_file,omitempty"`
+	RequireClientCert bool   `yaml:"require_client_cert,omitempty"`
+}
+
+// BuildTLSConfig loads the certificate/key (and, if configured, the CA used
+// to validate client certificates) named by cfg into a *tls.Config suitable
+// for the MySQL listener. Returns (nil, nil) when cfg is nil.
+func BuildTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate/key: %w", err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file '%s': %w", cfg.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA file '%s'", cfg.CAFile)
+		}
+		tlsCfg.ClientCAs = pool
+	}
+
+	if cfg.RequireClientCert {
+		if tlsCfg.ClientCAs == nil {
+			return nil, fmt.Errorf("require_client_cert is set but no ca_file was provided")
+		}
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if cfg.CAFile != "" {
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsCfg, nil
+}
+
+// clientCertUser returns the Dolt user name a verified client certificate
+// maps to, preferring the certificate's CN and falling back to its first
+// DNS SAN. Returns "" if state carries no verified certificate.
+func clientCertUser(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// certUserRegistry records, for each currently-open TLS connection, the Dolt
+// user its client certificate maps to (if any). mysql.AuthServer.ValidateHash
+// only has access to the connection's remote address, not its
+// tls.ConnectionState, so secureTransportListener populates this registry at
+// accept time and ValidateHash consults it by remoteAddr.
+type certUserRegistry struct {
+	mu    sync.Mutex
+	users map[string]string
+}
+
+func newCertUserRegistry() *certUserRegistry {
+	return &certUserRegistry{users: make(map[string]string)}
+}
+
+func (r *certUserRegistry) set(remoteAddr, user string) {
+	if user == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[remoteAddr] = user
+}
+
+func (r *certUserRegistry) remove(remoteAddr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, remoteAddr)
+}
+
+func (r *certUserRegistry) get(remoteAddr string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.users[remoteAddr]
+}
+
+// secureTransportListener wraps a TLS net.Listener so that the handshake
+// completes, and any client certificate is recorded in certUsers, before the
+// connection is handed to go-mysql-server. requireSecureTransport alone
+// doesn't need this wrapper (go-mysql-server only ever sees *tls.Conn once
+// the listener itself is tls.NewListener-wrapped) but mapping a client
+// certificate to a Dolt user does: ValidateHash never sees the net.Conn, so
+// the mapping has to be captured here, keyed by remote address, and looked
+// up from ValidateHash later.
+type secureTransportListener struct {
+	net.Listener
+	certUsers *certUserRegistry
+}
+
+func newSecureTransportListener(inner net.Listener, certUsers *certUserRegistry) *secureTransportListener {
+	return &secureTransportListener{Listener: inner, certUsers: certUsers}
+}
+
+func (l *secureTransportListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return conn, nil
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	remoteAddr := tlsConn.RemoteAddr().String()
+	if user := clientCertUser(tlsConn.ConnectionState()); user != "" {
+		l.certUsers.set(remoteAddr, user)
+	}
+
+	return &certUserConn{Conn: tlsConn, remoteAddr: remoteAddr, certUsers: l.certUsers}, nil
+}
+
+// certUserConn removes its entry from certUsers on Close, so the registry
+// doesn't grow unboundedly across the life of the listener.
+type certUserConn struct {
+	net.Conn
+	remoteAddr string
+	certUsers  *certUserRegistry
+}
+
+func (c *certUserConn) Close() error {
+	c.certUsers.remove(c.remoteAddr)
+	return c.Conn.Close()
+}
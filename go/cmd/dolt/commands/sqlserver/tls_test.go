@@ -0,0 +1,258 @@
+// Copyright 2019 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/gocraft/dbr/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/dtestutils"
+)
+
+// generateSelfSignedCertForTest returns a throwaway self-signed certificate
+// and key, PEM encoded, valid for "localhost".
+func generateSelfSignedCertForTest(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	return certPEM, keyPEM
+}
+
+// writeSelfSignedCert writes a throwaway self-signed cert/key pair to dir
+// and returns their paths, for use as TLS test fixtures.
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := generateSelfSignedCertForTest(t)
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15600).
+		withTLS(certFile, keyFile, "", false)
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	err := sc.WaitForStart()
+	require.NoError(t, err)
+
+	require.NoError(t, mysqldriver.RegisterTLSConfig("custom", &tls.Config{InsecureSkipVerify: true}))
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt?tls=custom", nil)
+	require.NoError(t, err)
+	err = conn.Close()
+	assert.NoError(t, err)
+}
+
+// TestServerRequireSecureTransportWithoutTLSFailsToStart exercises the real
+// failure mode of require_secure_transport: an operator sets it without also
+// configuring listener.tls:, so there's no TLS for it to require. Serve must
+// refuse to start rather than silently accepting plaintext connections.
+func TestServerRequireSecureTransportWithoutTLSFailsToStart(t *testing.T) {
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15601)
+	serverConfig.(*commandLineServerConfig).requireSecureTransport = true
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	_, err := Serve(context.Background(), "", serverConfig, sc, env)
+	assert.Error(t, err)
+	assert.Error(t, sc.WaitForStart())
+}
+
+// TestServerRequireSecureTransportAllowsTLSConnection is the companion
+// positive case: once listener.tls: is configured, require_secure_transport
+// starts normally and a client connecting over TLS is served as usual.
+func TestServerRequireSecureTransportAllowsTLSConnection(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15602).
+		withTLS(certFile, keyFile, "", false)
+	serverConfig.(*commandLineServerConfig).requireSecureTransport = true
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	require.NoError(t, sc.WaitForStart())
+
+	require.NoError(t, mysqldriver.RegisterTLSConfig("require-secure-transport", &tls.Config{InsecureSkipVerify: true}))
+	conn, err := dbr.Open("mysql", ConnectionString(serverConfig)+"dolt?tls=require-secure-transport", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var people []testPerson
+	_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+	assert.NoError(t, err)
+}
+
+// TestServerClientCertAuthentication exercises mutual TLS: a client that
+// presents a certificate signed by the configured CA is authenticated as the
+// Dolt user named by the certificate's CN, without presenting a password.
+func TestServerClientCertAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	caCertPEM, caKeyPEM := generateSelfSignedCertForTest(t)
+	caCertFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caCertFile, caCertPEM, 0600))
+
+	caCert, caKey := parseCertForTest(t, caCertPEM, caKeyPEM)
+	serverCertPEM, serverKeyPEM := generateCertSignedByForTest(t, caCert, caKey, "localhost", false)
+	serverCertFile := filepath.Join(dir, "server.crt")
+	serverKeyFile := filepath.Join(dir, "server.key")
+	require.NoError(t, os.WriteFile(serverCertFile, serverCertPEM, 0600))
+	require.NoError(t, os.WriteFile(serverKeyFile, serverKeyPEM, 0600))
+
+	clientCertPEM, clientKeyPEM := generateCertSignedByForTest(t, caCert, caKey, "alice", true)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	env := dtestutils.CreateEnvWithSeedData(t)
+	serverConfig := DefaultServerConfig().
+		withLogLevel(LogLevel_Fatal).
+		withPort(15603).
+		withTLS(serverCertFile, serverKeyFile, caCertFile, true)
+
+	sc := CreateServerController()
+	defer sc.StopServer()
+	go func() {
+		_, _ = Serve(context.Background(), "", serverConfig, sc, env)
+	}()
+	require.NoError(t, sc.WaitForStart())
+
+	require.NoError(t, mysqldriver.RegisterTLSConfig("client-cert", &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+	}))
+	// alice isn't a configured user/password, but the client certificate's
+	// CN maps straight to the Dolt user, bypassing password auth entirely.
+	conn, err := dbr.Open("mysql", "alice:@tcp(127.0.0.1:15603)/dolt?tls=client-cert", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var people []testPerson
+	_, err = conn.NewSession(nil).Select("*").From("people").LoadContext(context.Background(), &people)
+	assert.NoError(t, err)
+}
+
+// parseCertForTest parses a PEM cert/key pair generated by
+// generateSelfSignedCertForTest back into their x509/ecdsa forms, for use as
+// a signing CA.
+func parseCertForTest(t *testing.T, certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	certBlock, _ := pem.Decode(certPEM)
+	require.NotNil(t, certBlock)
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	require.NoError(t, err)
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	require.NotNil(t, keyBlock)
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	require.NoError(t, err)
+
+	return cert, key
+}
+
+// generateCertSignedByForTest returns a throwaway certificate/key pair, PEM
+// encoded, with commonName as its Subject CN, signed by caCert/caKey. When
+// client is true the certificate is suitable for client authentication;
+// otherwise it's suitable for server authentication against "localhost".
+func generateCertSignedByForTest(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string, client bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{commonName}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, caCert, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	return certPEM, keyPEM
+}